@@ -0,0 +1,143 @@
+// Package bencode implements just enough of the bencode encoding used by
+// the BitTorrent wire protocols (KRPC/DHT, PEX, torrent files) for this
+// client's needs: dictionaries, lists, byte strings, and integers.
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Encode serializes v into bencode. Supported types are string, []byte,
+// int64 (and int, promoted), []interface{}, and map[string]interface{}.
+func Encode(v interface{}) []byte {
+	var buf bytes.Buffer
+	encodeValue(&buf, v)
+	return buf.Bytes()
+}
+
+func encodeValue(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(val), val)
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(val))
+		buf.Write(val)
+	case int:
+		fmt.Fprintf(buf, "i%de", val)
+	case int64:
+		fmt.Fprintf(buf, "i%de", val)
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range val {
+			encodeValue(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		buf.WriteByte('d')
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys) // bencode dicts must be key-sorted
+		for _, k := range keys {
+			encodeValue(buf, k)
+			encodeValue(buf, val[k])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
+
+// Decode parses the bencoded value at the start of b, returning it as a
+// string, int64, []interface{}, or map[string]interface{}.
+func Decode(b []byte) (interface{}, error) {
+	v, _, err := decodeValue(b)
+	return v, err
+}
+
+func decodeValue(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("bencode: unexpected end of input")
+	}
+
+	switch {
+	case b[0] == 'i':
+		return decodeInt(b)
+	case b[0] == 'l':
+		return decodeList(b)
+	case b[0] == 'd':
+		return decodeDict(b)
+	case b[0] >= '0' && b[0] <= '9':
+		return decodeString(b)
+	default:
+		return nil, nil, fmt.Errorf("bencode: invalid type marker %q", b[0])
+	}
+}
+
+func decodeInt(b []byte) (interface{}, []byte, error) {
+	end := bytes.IndexByte(b, 'e')
+	if end < 0 {
+		return nil, nil, fmt.Errorf("bencode: unterminated integer")
+	}
+	n, err := strconv.ParseInt(string(b[1:end]), 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bencode: invalid integer: %w", err)
+	}
+	return n, b[end+1:], nil
+}
+
+func decodeString(b []byte) (interface{}, []byte, error) {
+	colon := bytes.IndexByte(b, ':')
+	if colon < 0 {
+		return nil, nil, fmt.Errorf("bencode: invalid string length")
+	}
+	n, err := strconv.Atoi(string(b[:colon]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("bencode: invalid string length: %w", err)
+	}
+	start := colon + 1
+	if start+n > len(b) {
+		return nil, nil, fmt.Errorf("bencode: string runs past end of input")
+	}
+	return string(b[start : start+n]), b[start+n:], nil
+}
+
+func decodeList(b []byte) (interface{}, []byte, error) {
+	rest := b[1:]
+	var list []interface{}
+	for len(rest) == 0 || rest[0] != 'e' {
+		v, next, err := decodeValue(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		list = append(list, v)
+		rest = next
+	}
+	return list, rest[1:], nil
+}
+
+func decodeDict(b []byte) (interface{}, []byte, error) {
+	rest := b[1:]
+	dict := make(map[string]interface{})
+	for len(rest) == 0 || rest[0] != 'e' {
+		keyVal, next, err := decodeValue(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, ok := keyVal.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("bencode: dict key must be a string")
+		}
+		val, next2, err := decodeValue(next)
+		if err != nil {
+			return nil, nil, err
+		}
+		dict[key] = val
+		rest = next2
+	}
+	return dict, rest[1:], nil
+}
@@ -0,0 +1,86 @@
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   interface{}
+		want interface{} // decoded form, when it differs from in (e.g. []byte -> string)
+	}{
+		{name: "string", in: "spam"},
+		{name: "empty string", in: ""},
+		{name: "bytes decode as string", in: []byte("spam"), want: "spam"},
+		{name: "positive int", in: 42, want: int64(42)},
+		{name: "negative int", in: -42, want: int64(-42)},
+		{name: "int64", in: int64(1 << 40)},
+		{name: "list", in: []interface{}{"spam", int64(42)}},
+		{name: "empty list", in: []interface{}(nil)},
+		{
+			name: "dict",
+			in: map[string]interface{}{
+				"cow":   "moo",
+				"spam":  "eggs",
+				"count": int64(3),
+			},
+		},
+		{
+			name: "nested",
+			in: map[string]interface{}{
+				"list": []interface{}{int64(1), int64(2), "three"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := Encode(tt.in)
+			got, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode(%q) returned error: %v", encoded, err)
+			}
+
+			want := tt.want
+			if want == nil {
+				want = tt.in
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("round trip of %#v = %#v, want %#v", tt.in, got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeKeysSorted(t *testing.T) {
+	in := map[string]interface{}{"zebra": "z", "apple": "a", "mango": "m"}
+	got := string(Encode(in))
+	want := "d5:apple1:a5:mango1:m5:zebra1:ze"
+	if got != want {
+		t.Errorf("Encode(%#v) = %q, want %q (dict keys must be sorted)", in, got, want)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{name: "empty input", in: ""},
+		{name: "unterminated integer", in: "i42"},
+		{name: "invalid integer", in: "i4x2e"},
+		{name: "string runs past end", in: "10:short"},
+		{name: "invalid type marker", in: "x"},
+		{name: "non-string dict key", in: "di1ei2ee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tt.in)); err == nil {
+				t.Errorf("Decode(%q) succeeded, want error", tt.in)
+			}
+		})
+	}
+}
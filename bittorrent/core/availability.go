@@ -0,0 +1,43 @@
+package core
+
+import (
+	"gotorrent/bittorrent/network"
+	"sync"
+)
+
+// availabilityTracker counts, for each piece index, how many connected
+// peers are known to have it. It's fed from each peer's initial Bitfield
+// and from MsgHave updates as they arrive, and backs RarestFirstRequestStrategy.
+type availabilityTracker struct {
+	mu     sync.Mutex
+	counts []int
+}
+
+func newAvailabilityTracker(numPieces int) *availabilityTracker {
+	return &availabilityTracker{counts: make([]int, numPieces)}
+}
+
+// addBitfield records one peer's full bitfield, e.g. right after handshake.
+func (a *availabilityTracker) addBitfield(bf network.Bitfield, numPieces int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := 0; i < numPieces; i++ {
+		if bf.HasPiece(i) {
+			a.counts[i]++
+		}
+	}
+}
+
+// have records a single MsgHave update from a peer.
+func (a *availabilityTracker) have(index int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.counts[index]++
+}
+
+// count returns how many peers are known to have the given piece.
+func (a *availabilityTracker) count(index int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.counts[index]
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	// unchokeSlots is the number of peers the choking algorithm keeps
+	// unchoked by rank, not counting the optimistic unchoke.
+	unchokeSlots = 4
+)
+
+// PeerConn is one active peer connection being served during Torrent.Seed:
+// the wire client, its transfer stats, and whether we're currently choking
+// it. mu guards choked and serializes every write to client.Conn, since the
+// choke rotation goroutine and the connection's own serve loop both write
+// to it concurrently.
+type PeerConn struct {
+	client *Client
+	stats  *PeerStats
+
+	mu     sync.Mutex
+	choked bool
+}
+
+// setChoked brings the connection's choke state in line with choked,
+// notifying the peer only when it actually changes.
+func (pc *PeerConn) setChoked(choked bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.choked == choked {
+		return
+	}
+	pc.choked = choked
+
+	var err error
+	if choked {
+		err = pc.client.SendChoke()
+	} else {
+		err = pc.client.SendUnchoke()
+	}
+	if err != nil {
+		log.Printf("Failed to send choke state to peer: %v", err)
+	}
+}
+
+// isChoked reports whether pc is currently choked.
+func (pc *PeerConn) isChoked() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.choked
+}
+
+// sendPiece writes a piece block to the peer, serialized against any
+// concurrent choke state change on the same connection.
+func (pc *PeerConn) sendPiece(index, begin int, block []byte) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.client.SendPiece(index, begin, block)
+}
+
+// chokeManager runs the standard BitTorrent tit-for-tat choking algorithm
+// over the set of peers currently connected to Torrent.Seed.
+type chokeManager struct {
+	mu    sync.Mutex
+	peers map[*PeerConn]struct{}
+}
+
+func newChokeManager() *chokeManager {
+	return &chokeManager{peers: make(map[*PeerConn]struct{})}
+}
+
+func (m *chokeManager) add(pc *PeerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers[pc] = struct{}{}
+}
+
+func (m *chokeManager) remove(pc *PeerConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers, pc)
+}
+
+// rotate unchokes the unchokeSlots peers with the highest download rate
+// (i.e. the ones sending us the most data), plus one additional random
+// "optimistic unchoke" when optimistic is true, and chokes everyone else.
+// Peers served by Seed never have downloads recorded, so during pure
+// seeding every rate is zero and which peers fill the slots is arbitrary.
+func (m *chokeManager) rotate(optimistic bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]*PeerConn, 0, len(m.peers))
+	for pc := range m.peers {
+		peers = append(peers, pc)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].stats.Snapshot().DownRate > peers[j].stats.Snapshot().DownRate
+	})
+
+	unchoked := make(map[*PeerConn]bool, unchokeSlots+1)
+	for i := 0; i < unchokeSlots && i < len(peers); i++ {
+		unchoked[peers[i]] = true
+	}
+
+	if optimistic {
+		var candidates []*PeerConn
+		for _, pc := range peers {
+			if !unchoked[pc] {
+				candidates = append(candidates, pc)
+			}
+		}
+		if len(candidates) > 0 {
+			unchoked[candidates[rand.Intn(len(candidates))]] = true
+		}
+	}
+
+	for _, pc := range peers {
+		pc.setChoked(!unchoked[pc])
+	}
+}
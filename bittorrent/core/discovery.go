@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"gotorrent/bittorrent/dht"
+	"gotorrent/bittorrent/network"
+	"log"
+)
+
+// startDiscovery launches the DHT and PEX peer sources alongside the
+// tracker's initial peer list, feeding every newly found peer address into
+// out. It returns a cancel func that stops both sources; callers should
+// defer it once the torrent no longer needs new peers.
+func (t *Torrent) startDiscovery(out chan<- network.Peer) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dhtClient, err := dht.New(t.InfoHash, t.ListenPort)
+	if err != nil {
+		log.Printf("discovery: DHT unavailable: %v", err)
+	} else {
+		go func() {
+			defer dhtClient.Close()
+			dhtClient.Run(ctx, out)
+		}()
+	}
+
+	t.pexNew = make(chan network.Peer, 32)
+	go t.forwardPEX(ctx, out)
+
+	return cancel
+}
+
+// forwardPEX relays peers learned from any connected peer's ut_pex messages
+// into out, so Download dispatches them to a new worker just like DHT finds.
+func (t *Torrent) forwardPEX(ctx context.Context, out chan<- network.Peer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case peer := <-t.pexNew:
+			select {
+			case out <- peer:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// markConnected records that a peer is already connected (or being
+// connected to), returning false if it was already known so callers don't
+// spawn a duplicate worker.
+func (t *Torrent) markConnected(peer network.Peer) bool {
+	addr := fmt.Sprintf("%s:%d", peer.IP, peer.Port)
+
+	t.peersMu.Lock()
+	defer t.peersMu.Unlock()
+	if t.connectedAddrs == nil {
+		t.connectedAddrs = make(map[string]bool)
+	}
+	if t.connectedAddrs[addr] {
+		return false
+	}
+	t.connectedAddrs[addr] = true
+	return true
+}
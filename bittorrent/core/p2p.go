@@ -3,11 +3,15 @@ package core
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"gotorrent/bittorrent/network"
+	"gotorrent/bittorrent/storage"
 	"gotorrent/ui"
+	"io"
 	"log"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -34,16 +38,19 @@ type pieceWork struct {
 
 type pieceResult struct {
 	index int
-	buf   []byte
 }
 
 type pieceProgress struct {
-	index      int
-	client     *Client
-	buf        []byte
-	downloaded int
-	requested  int
-	backlog    int
+	index        int
+	client       *Client
+	buf          []byte
+	downloaded   int
+	requested    int
+	backlog      int
+	availability *availabilityTracker // may be nil, e.g. in tests
+	stats        *PeerStats           // may be nil, e.g. in tests
+	pex          *pexConn             // may be nil, e.g. in tests
+	knownPeers   []network.Peer
 }
 
 func (state *pieceProgress) readMessage() error {
@@ -67,6 +74,9 @@ func (state *pieceProgress) readMessage() error {
 			return err
 		}
 		state.client.Bitfield.SetPiece(index)
+		if state.availability != nil {
+			state.availability.have(index)
+		}
 	case network.MsgPiece:
 		n, err := network.ParsePiece(state.index, state.buf, msg)
 		if err != nil {
@@ -74,6 +84,22 @@ func (state *pieceProgress) readMessage() error {
 		}
 		state.downloaded += n
 		state.backlog--
+		if state.stats != nil {
+			state.stats.RecordDownload(n)
+		}
+	case network.MsgExtended:
+		id, payload, err := network.ParseExtended(msg)
+		if err != nil {
+			return err
+		}
+		if state.pex == nil {
+			break
+		}
+		if id == 0 {
+			handleExtendedHandshake(state.pex, payload, state.knownPeers)
+		} else if id == ourPEXExtendedID {
+			handlePEXMessage(state.pex, payload)
+		}
 	}
 	return nil
 }
@@ -88,11 +114,20 @@ func adjustBacklog(success bool) {
 	}
 }
 
-func attemptDownloadPiece(c *Client, pw *pieceWork) ([]byte, error) {
+// errPieceCancelled is returned by attemptDownloadPiece when another peer
+// finished the piece first during endgame mode. It isn't a peer fault, so
+// callers shouldn't disconnect or requeue on it.
+var errPieceCancelled = errors.New("piece cancelled: completed by another peer")
+
+func attemptDownloadPiece(c *Client, pw *pieceWork, cancel <-chan struct{}, availability *availabilityTracker, stats *PeerStats, pex *pexConn, knownPeers []network.Peer) ([]byte, error) {
 	state := pieceProgress{
-		index:  pw.index,
-		client: c,
-		buf:    make([]byte, pw.length),
+		index:        pw.index,
+		client:       c,
+		buf:          make([]byte, pw.length),
+		availability: availability,
+		stats:        stats,
+		pex:          pex,
+		knownPeers:   knownPeers,
 	}
 
 	// Setting a deadline helps get unresponsive peers unstuck.
@@ -108,6 +143,18 @@ func attemptDownloadPiece(c *Client, pw *pieceWork) ([]byte, error) {
 	}()
 
 	for state.downloaded < pw.length {
+		select {
+		case <-cancel:
+			// Send our own cancels and drain from this goroutine, not the
+			// winner's: reaching into this connection from another worker's
+			// goroutine would race with the SendRequest/SetDeadline calls
+			// below.
+			cancelPeerRequests(c, pw)
+			drainCancelledPiece(&state)
+			return nil, errPieceCancelled
+		default:
+		}
+
 		// If unchoked, send requests until we have enough unfulfilled requests
 		if !state.client.Choked {
 			for state.backlog < backlog && state.requested < pw.length {
@@ -138,6 +185,21 @@ func attemptDownloadPiece(c *Client, pw *pieceWork) ([]byte, error) {
 	return state.buf, nil
 }
 
+// drainCancelledPiece reads off any blocks for a cancelled piece that are
+// already in flight from the peer, so they don't get misread as belonging
+// to whatever piece this worker downloads next.
+func drainCancelledPiece(state *pieceProgress) {
+	deadline := time.Now().Add(5 * time.Second)
+	for state.backlog > 0 {
+		if err := state.client.Conn.SetDeadline(deadline); err != nil {
+			return
+		}
+		if err := state.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
 func checkIntegrity(pw *pieceWork, buf []byte) error {
 	hash := sha1.Sum(buf)
 	if !bytes.Equal(hash[:], pw.hash[:]) {
@@ -146,7 +208,7 @@ func checkIntegrity(pw *pieceWork, buf []byte) error {
 	return nil
 }
 
-func (t *Torrent) startDownloadWorker(peer network.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
+func (t *Torrent) startDownloadWorker(peer network.Peer, queue *pieceQueue, results chan *pieceResult) {
 	c, err := NewClient(peer, t.PeerID, t.InfoHash)
 	if err != nil {
 		log.Printf("Could not handshake with %s. Disconnecting\n", peer.IP)
@@ -155,6 +217,13 @@ func (t *Torrent) startDownloadWorker(peer network.Peer, workQueue chan *pieceWo
 	}
 	defer c.Conn.Close()
 	log.Printf("Completed handshake with %s\n", peer.IP)
+	t.availability.addBitfield(c.Bitfield, len(t.PieceHashes))
+	stats := t.statsFor(fmt.Sprintf("%s:%d", peer.IP, peer.Port))
+
+	pex := &pexConn{client: c, discovered: t.pexNew}
+	if err := sendExtendedHandshake(c); err != nil {
+		log.Printf("Failed to send extension handshake: %v", err)
+	}
 
 	if err := c.SendUnchoke(); err != nil {
 		log.Printf("Failed to send unchoke: %v", err)
@@ -164,31 +233,90 @@ func (t *Torrent) startDownloadWorker(peer network.Peer, workQueue chan *pieceWo
 		log.Printf("Failed to send interested: %v", err)
 	}
 
-	for pw := range workQueue {
-		if !c.Bitfield.HasPiece(pw.index) {
-			workQueue <- pw // Put piece back on the queue
-			continue
+	for {
+		// next blocks until the queue holds a piece covered by this peer's
+		// bitfield, then hands out the highest-priority such piece. In
+		// endgame mode several workers can be handed the same piece at once.
+		pw, cancel, ok := queue.next(c)
+		if !ok {
+			return
 		}
 
 		// Download the piece
-		buf, err := attemptDownloadPiece(c, pw)
+		buf, err := attemptDownloadPiece(c, pw, cancel, t.availability, stats, pex, t.Peers)
 		if err != nil {
+			if errors.Is(err, errPieceCancelled) {
+				continue
+			}
 			log.Println("Exiting", err)
-			workQueue <- pw // Put piece back on the queue
+			queue.requeue(pw, PriorityNormal)
 			return
 		}
 
-		err = checkIntegrity(pw, buf)
+		begin, _ := t.calculateBoundsForPiece(pw.index)
+		piece, err := t.storage.Piece(storage.PieceInfo{Index: pw.index, Length: pw.length, Offset: int64(begin)})
 		if err != nil {
+			log.Printf("Failed to open storage for piece #%d: %v", pw.index, err)
+			queue.requeue(pw, PriorityNormal)
+			continue
+		}
+		if _, err := piece.WriteAt(buf, 0); err != nil {
+			log.Printf("Failed to write piece #%d to storage: %v", pw.index, err)
+			queue.requeue(pw, PriorityNormal)
+			continue
+		}
+
+		// Re-read from storage rather than trusting the in-memory buffer, so
+		// the integrity check covers what's actually on disk.
+		verify := make([]byte, pw.length)
+		if _, err := piece.ReadAt(verify, 0); err != nil {
+			log.Printf("Failed to read back piece #%d for verification: %v", pw.index, err)
+			queue.requeue(pw, PriorityNormal)
+			continue
+		}
+		if err := checkIntegrity(pw, verify); err != nil {
 			log.Printf("Piece #%d failed integrity check\n", pw.index)
-			workQueue <- pw // Put piece back on the queue
+			queue.requeue(pw, PriorityNormal)
 			continue
 		}
 
+		if err := piece.MarkComplete(); err != nil {
+			log.Printf("Failed to mark piece #%d complete: %v", pw.index, err)
+		}
+
 		if err := c.SendHave(pw.index); err != nil {
 			log.Printf("Failed to send have: %v", err)
 		}
-		results <- &pieceResult{pw.index, buf}
+
+		// In endgame mode several workers can race the same piece; only the
+		// first to finish reports a result. complete() closes each loser's
+		// cancel channel, but it's up to each loser's own goroutine to act
+		// on it in attemptDownloadPiece -- sending the cancel from here
+		// would race with that worker's own writes to its connection.
+		alreadyDone, _ := queue.complete(pw, c)
+		if alreadyDone {
+			continue
+		}
+
+		results <- &pieceResult{pw.index}
+	}
+}
+
+// cancelPeerRequests tells c's peer that pw has already been downloaded
+// elsewhere, one MsgCancel for every block of the piece, whether or not
+// that block is still outstanding. Called from c's own
+// download-worker goroutine (e.g. when pw's endgame cancel channel fires),
+// never from another worker's, since c.Conn isn't safe to write from two
+// goroutines at once.
+func cancelPeerRequests(c *Client, pw *pieceWork) {
+	for begin := 0; begin < pw.length; begin += MaxBlockSize {
+		length := MaxBlockSize
+		if pw.length-begin < length {
+			length = pw.length - begin
+		}
+		if err := c.SendCancel(pw.index, begin, length); err != nil {
+			log.Printf("Failed to send cancel for piece #%d: %v", pw.index, err)
+		}
 	}
 }
 
@@ -206,48 +334,192 @@ func (t *Torrent) calculatePieceSize(index int) int {
 	return end - begin
 }
 
-// Download downloads the torrent. This stores the entire file in memory.
-func (t *Torrent) Download() ([]byte, error) {
+// endgameThreshold is the number of outstanding pieces below which the
+// download switches to endgame mode, broadcasting remaining requests to
+// every idle peer instead of a single assignee.
+func endgameThreshold(numPeers int) int {
+	return 2 * numPeers
+}
+
+// waitForRange blocks until every piece covering [off, off+len(p)) has
+// downloaded, then reads it from storage into p. It backs TorrentReader.
+func (t *Torrent) waitForRange(off int64, p []byte) (int, error) {
+	end := off + int64(len(p))
+	if end > int64(t.Length) {
+		end = int64(t.Length)
+	}
+	first := int(off) / t.PieceLength
+	last := int(end-1) / t.PieceLength
+
+	t.mu.Lock()
+	for {
+		ready := true
+		for i := first; i <= last; i++ {
+			if !t.havePieces[i] {
+				ready = false
+				break
+			}
+		}
+		if ready {
+			break
+		}
+		t.cond.Wait()
+	}
+	t.mu.Unlock()
+
+	n := 0
+	for i := first; i <= last; i++ {
+		pieceBegin, pieceEnd := t.calculateBoundsForPiece(i)
+		readBegin, readEnd := off, end
+		if int64(pieceBegin) > readBegin {
+			readBegin = int64(pieceBegin)
+		}
+		if int64(pieceEnd) < readEnd {
+			readEnd = int64(pieceEnd)
+		}
+
+		piece, err := t.storage.Piece(storage.PieceInfo{Index: i, Length: pieceEnd - pieceBegin, Offset: int64(pieceBegin)})
+		if err != nil {
+			return n, err
+		}
+		dst := p[readBegin-off : readEnd-off]
+		if _, err := piece.ReadAt(dst, readBegin-int64(pieceBegin)); err != nil {
+			return n, err
+		}
+		n += len(dst)
+	}
+
+	if end == int64(t.Length) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ensureInit lazily initializes t's piece queue, wait condition, and
+// storage backend, so a TorrentReader obtained before (or concurrently
+// with) Download() has something to dereference instead of nil-panicking
+// on the streaming read path. It's idempotent: later calls, including the
+// one Download() itself makes, are no-ops once this has run.
+func (t *Torrent) ensureInit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.storage == nil {
+		t.storage = storage.NewMemory()
+	}
+	if t.cond == nil {
+		t.cond = sync.NewCond(&t.mu)
+	}
+	if t.havePieces == nil {
+		t.havePieces = make([]bool, len(t.PieceHashes))
+	}
+	if t.queue != nil {
+		return
+	}
+
+	t.queue = newPieceQueue(len(t.PieceHashes))
+	t.availability = newAvailabilityTracker(len(t.PieceHashes))
+	t.queue.setAvailability(t.availability)
+	if t.RequestStrategy == nil {
+		bootstrapPieces := 4
+		if len(t.PieceHashes) < bootstrapPieces {
+			bootstrapPieces = len(t.PieceHashes)
+		}
+		t.RequestStrategy = RarestFirstRequestStrategy{BootstrapPieces: bootstrapPieces}
+	}
+	t.queue.setStrategy(t.RequestStrategy)
+}
+
+// Download downloads the torrent, writing pieces directly to t.storage as
+// they arrive instead of buffering the whole file in memory. If t.storage
+// hasn't been set, it falls back to an in-memory storage.Client so existing
+// callers and tests keep working.
+func (t *Torrent) Download() error {
 	log.Println("Starting download for", t.Name)
 	fmt.Printf("Starting download for \033[36m%s\033[0m...\n", t.Name)
-	// Init queues for workers to retrieve work and send results
-	workQueue := make(chan *pieceWork, len(t.PieceHashes))
+
+	t.ensureInit()
+
+	// Resume support: skip pieces that are already complete on disk.
+	have, err := storage.ScanComplete(t.storage, t.PieceHashes, t.PieceLength, t.Length)
+	if err != nil {
+		log.Printf("Resume scan failed, starting fresh: %v", err)
+		have = make([]bool, len(t.PieceHashes))
+	}
+
 	results := make(chan *pieceResult)
+	t.mu.Lock()
+	t.havePieces = have
+	t.mu.Unlock()
 
 	// Initialize the progress bar
 	pb := ui.NewPBar()
 	pb.SignalHandler()
 	pb.Total = uint16(100)
 
+	donePieces := 0
 	for index, hash := range t.PieceHashes {
+		if t.havePieces[index] {
+			donePieces++
+			continue
+		}
 		length := t.calculatePieceSize(index)
-		workQueue <- &pieceWork{index, hash, length}
+		t.queue.enqueue(&pieceWork{index, hash, length}, PriorityNormal)
 	}
+	t.queue.setDownloaded(donePieces)
 
-	// Start workers
+	// Start workers. Besides the tracker's initial snapshot, new peers
+	// found mid-download via DHT or PEX are handed a worker too, as they
+	// arrive on discovered.
+	discovered := make(chan network.Peer, 32)
+	stopDiscovery := t.startDiscovery(discovered)
+	defer stopDiscovery()
+
+	activePeers := 0
 	for _, peer := range t.Peers {
-		go t.startDownloadWorker(peer, workQueue, results)
+		t.markConnected(peer)
+		activePeers++
+		go t.startDownloadWorker(peer, t.queue, results)
 	}
 
-	// Collect results into a buffer until full
-	buf := make([]byte, t.Length)
-	donePieces := 0
 	for donePieces < len(t.PieceHashes) {
-		res := <-results
-		begin, end := t.calculateBoundsForPiece(res.index)
-		copy(buf[begin:end], res.buf)
-		donePieces++
+		select {
+		case peer := <-discovered:
+			if !t.markConnected(peer) {
+				continue
+			}
+			activePeers++
+			log.Printf("Discovered new peer %s\n", peer.IP)
+			go t.startDownloadWorker(peer, t.queue, results)
+			continue
+		case res := <-results:
+			t.mu.Lock()
+			t.havePieces[res.index] = true
+			t.cond.Broadcast()
+			t.mu.Unlock()
+
+			donePieces++
+			t.queue.setDownloaded(donePieces)
+
+			// Once only a handful of pieces are left, a single slow peer can
+			// stall the whole download. Broadcast the pieces still queued to
+			// every idle peer instead of exclusively assigning them; pieces
+			// already handed to a worker stay with that worker alone.
+			if outstanding := len(t.PieceHashes) - donePieces; outstanding > 0 && outstanding < endgameThreshold(activePeers) {
+				t.queue.enterEndgame()
+			}
 
-		percent := float64(donePieces) / float64(len(t.PieceHashes)) * 100 // Convert percent to float64
-		numWorkers := runtime.NumGoroutine() - 1                           // subtract 1 for main thread
+			percent := float64(donePieces) / float64(len(t.PieceHashes)) * 100 // Convert percent to float64
+			numWorkers := runtime.NumGoroutine() - 1                           // subtract 1 for main thread
 
-		// Save into a logs file
-		log.Printf("(%0.2f%%) Downloaded piece #%d from %d peers\n", percent, res.index, numWorkers)
-		pb.RenderPBar(percent, res.index, numWorkers)
+			// Save into a logs file
+			log.Printf("(%0.2f%%) Downloaded piece #%d from %d peers\n", percent, res.index, numWorkers)
+			pb.RenderPBar(percent, res.index, numWorkers)
+		}
 	}
-	close(workQueue)
+	t.queue.close()
 	pb.CleanUp()
 	fmt.Printf("\n\033[32mFile %s downloaded!\033[0m\n", t.Name)
 	fmt.Println("Check the output directory.")
-	return buf, nil
+	return nil
 }
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"encoding/binary"
+	"gotorrent/bittorrent/bencode"
+	"gotorrent/bittorrent/network"
+	"log"
+	"net"
+)
+
+// ourPEXExtendedID is the local identifier we advertise for ut_pex in the
+// BEP 10 extended handshake. Peers echo back their own chosen ID for it,
+// which is what we must use when sending them ut_pex messages.
+const ourPEXExtendedID = 1
+
+// pexConn tracks the ut_pex extension state for one connection.
+type pexConn struct {
+	client     *Client
+	peerID     byte // peer's chosen extended ID for ut_pex, 0 until their handshake arrives
+	discovered chan<- network.Peer
+}
+
+// sendExtendedHandshake advertises our extension support to c. It must be
+// sent right after the regular handshake, before any other messages.
+func sendExtendedHandshake(c *Client) error {
+	payload := bencode.Encode(map[string]interface{}{
+		"m": map[string]interface{}{
+			"ut_pex": int64(ourPEXExtendedID),
+		},
+	})
+	return c.SendExtended(0, payload)
+}
+
+// handleExtendedHandshake reads the peer's chosen ut_pex ID out of an
+// incoming extended handshake payload (extended message ID 0), and, once
+// known, shares our current peer list with them.
+func handleExtendedHandshake(pc *pexConn, payload []byte, knownPeers []network.Peer) {
+	v, err := bencode.Decode(payload)
+	if err != nil {
+		return
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	m, ok := dict["m"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	id, ok := m["ut_pex"].(int64)
+	if !ok || id == 0 {
+		return
+	}
+	pc.peerID = byte(id)
+
+	if len(knownPeers) > 0 {
+		// Sent from the caller's goroutine, which owns writes to this
+		// connection.
+		if err := pc.client.SendExtended(pc.peerID, encodePEXAdded(knownPeers)); err != nil {
+			log.Printf("pex: failed to share peer list with %s: %v", pc.client.Conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// handlePEXMessage decodes an incoming ut_pex payload and forwards any
+// newly-advertised peers to pc.discovered, dropping them if nobody's
+// reading (the discovery channel is buffered and best-effort).
+func handlePEXMessage(pc *pexConn, payload []byte) {
+	peers, err := decodePEXAdded(payload)
+	if err != nil {
+		return
+	}
+	for _, peer := range peers {
+		select {
+		case pc.discovered <- peer:
+		default:
+		}
+	}
+}
+
+// encodeCompactPeers serializes peers using the same compact format as BEP
+// 5's peer lists: 4-byte IPv4 address + 2-byte big-endian port per peer.
+func encodeCompactPeers(peers []network.Peer) []byte {
+	buf := make([]byte, 0, len(peers)*6)
+	for _, p := range peers {
+		ip4 := p.IP.To4()
+		if ip4 == nil {
+			continue // ut_pex is IPv4-only; BEP 11 defines a separate ut_pex6 for IPv6
+		}
+		buf = append(buf, ip4...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(p.Port))
+	}
+	return buf
+}
+
+func decodeCompactPeers(b []byte) []network.Peer {
+	const entrySize = 6
+	peers := make([]network.Peer, 0, len(b)/entrySize)
+	for i := 0; i+entrySize <= len(b); i += entrySize {
+		ip := net.IPv4(b[i], b[i+1], b[i+2], b[i+3])
+		port := binary.BigEndian.Uint16(b[i+4 : i+6])
+		peers = append(peers, network.Peer{IP: ip, Port: int(port)})
+	}
+	return peers
+}
+
+// encodePEXAdded bencodes a ut_pex message advertising the given peers as
+// newly available, compact-encoded per BEP 5/11.
+func encodePEXAdded(peers []network.Peer) []byte {
+	return bencode.Encode(map[string]interface{}{
+		"added":   string(encodeCompactPeers(peers)),
+		"added.f": string(make([]byte, len(peers))), // no flags known, all zero
+		"dropped": "",
+	})
+}
+
+// decodePEXAdded extracts the newly-available peers out of an incoming
+// ut_pex message, ignoring drops; we let stale connections fail on their
+// own rather than tracking peer liveness via PEX drops.
+func decodePEXAdded(payload []byte) ([]network.Peer, error) {
+	v, err := bencode.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	added, ok := dict["added"].(string)
+	if !ok {
+		return nil, nil
+	}
+	return decodeCompactPeers([]byte(added)), nil
+}
@@ -0,0 +1,25 @@
+package core
+
+// PiecePriority controls the order in which a Torrent's pieces are
+// requested from peers. Workers always serve the highest-priority piece
+// available from their peer's bitfield; ties within the same priority are
+// broken by the Torrent's RequestStrategy. Modeled after anacrolix/torrent's
+// PiecePriority.
+type PiecePriority int
+
+const (
+	// PriorityNone means the piece won't be downloaded at all.
+	PriorityNone PiecePriority = iota
+	// PriorityNormal is the default priority for all pieces in a plain
+	// Download().
+	PriorityNormal
+	// PriorityReadahead is used for pieces within a TorrentReader's
+	// readahead window.
+	PriorityReadahead
+	// PriorityNext is the piece immediately following a TorrentReader's
+	// current read offset.
+	PriorityNext
+	// PriorityNow is the piece covering a TorrentReader's current read
+	// offset; it preempts everything else.
+	PriorityNow
+)
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// pieceQueue is the priority-aware work queue shared by all of a Torrent's
+// download workers. It replaces the old unordered workQueue channel: workers
+// block in next() until a piece their peer's bitfield covers is queued, and
+// are handed the highest-priority such piece, rather than pulling pieces in
+// whatever order they were enqueued.
+type pieceQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  map[int]*queuedPiece
+	order  pieceHeap
+	closed bool
+
+	// endgame, once set, means pieces are no longer removed from the queue
+	// on dispatch: every idle worker whose peer has a still-queued piece is
+	// handed it too, and inFlight tracks who's attempting what so
+	// the first one to finish can cancel the rest.
+	endgame  bool
+	inFlight map[int][]*endgameAttempt
+	done     map[int]bool
+
+	// strategy breaks ties among candidate pieces at the same priority that
+	// a peer's bitfield covers. Defaults to FIFORequestStrategy if unset.
+	strategy     RequestStrategy
+	availability *availabilityTracker
+	downloaded   int // pieces completed so far, read by RarestFirst's bootstrap check
+}
+
+func (q *pieceQueue) setStrategy(s RequestStrategy) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.strategy = s
+}
+
+func (q *pieceQueue) setAvailability(a *availabilityTracker) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.availability = a
+}
+
+func (q *pieceQueue) setDownloaded(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.downloaded = n
+}
+
+// endgameAttempt is one worker's in-progress request for a piece during
+// endgame mode.
+type endgameAttempt struct {
+	client *Client
+	cancel chan struct{}
+}
+
+type queuedPiece struct {
+	work     *pieceWork
+	priority PiecePriority
+	heapIdx  int
+}
+
+type pieceHeap []*queuedPiece
+
+func (h pieceHeap) Len() int { return len(h) }
+
+func (h pieceHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].work.index < h[j].work.index
+}
+
+func (h pieceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIdx = i
+	h[j].heapIdx = j
+}
+
+func (h *pieceHeap) Push(x any) {
+	qp := x.(*queuedPiece)
+	qp.heapIdx = len(*h)
+	*h = append(*h, qp)
+}
+
+func (h *pieceHeap) Pop() any {
+	old := *h
+	n := len(old)
+	qp := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return qp
+}
+
+func newPieceQueue(capacity int) *pieceQueue {
+	q := &pieceQueue{
+		items: make(map[int]*queuedPiece, capacity),
+		order: make(pieceHeap, 0, capacity),
+		done:  make(map[int]bool, capacity),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// enqueue adds pw to the queue at priority, or raises its priority if it's
+// already queued.
+func (q *pieceQueue) enqueue(pw *pieceWork, priority PiecePriority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.enqueueLocked(pw, priority)
+}
+
+func (q *pieceQueue) enqueueLocked(pw *pieceWork, priority PiecePriority) {
+	if qp, ok := q.items[pw.index]; ok {
+		if priority > qp.priority {
+			qp.priority = priority
+			heap.Fix(&q.order, qp.heapIdx)
+			q.cond.Broadcast()
+		}
+		return
+	}
+	qp := &queuedPiece{work: pw, priority: priority}
+	q.items[pw.index] = qp
+	heap.Push(&q.order, qp)
+	q.cond.Broadcast()
+}
+
+// raise bumps the priority of an already-queued piece. It's a no-op if the
+// piece isn't queued, e.g. because it's already in flight or downloaded.
+func (q *pieceQueue) raise(index int, priority PiecePriority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	qp, ok := q.items[index]
+	if !ok || priority <= qp.priority {
+		return
+	}
+	qp.priority = priority
+	heap.Fix(&q.order, qp.heapIdx)
+	q.cond.Broadcast()
+}
+
+// next blocks until a queued piece covered by c's bitfield becomes
+// available, or the queue is closed. Outside endgame mode, the returned
+// piece is removed from the queue. In endgame mode it's left queued and c
+// is recorded as an in-flight attempt, so other idle workers can be handed
+// the same piece; the returned cancel channel is closed if another worker
+// finishes the piece first.
+func (q *pieceQueue) next(c *Client) (*pieceWork, <-chan struct{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if qp := q.selectLocked(c); qp != nil {
+			cancel := make(chan struct{})
+			if q.endgame {
+				q.inFlight[qp.work.index] = append(q.inFlight[qp.work.index], &endgameAttempt{client: c, cancel: cancel})
+			} else {
+				heap.Remove(&q.order, qp.heapIdx)
+				delete(q.items, qp.work.index)
+			}
+			return qp.work, cancel, true
+		}
+		if q.closed {
+			return nil, nil, false
+		}
+		q.cond.Wait()
+	}
+}
+
+// selectLocked picks the piece to hand c next: among the pieces c's
+// bitfield covers, it narrows to the highest priority present, then breaks
+// ties with the queue's RequestStrategy. Must be called with q.mu held.
+func (q *pieceQueue) selectLocked(c *Client) *queuedPiece {
+	var candidates []*queuedPiece
+	highest := PriorityNone
+	for _, qp := range q.order {
+		if !c.Bitfield.HasPiece(qp.work.index) {
+			continue
+		}
+		switch {
+		case qp.priority > highest:
+			highest = qp.priority
+			candidates = []*queuedPiece{qp}
+		case qp.priority == highest:
+			candidates = append(candidates, qp)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	strategy := q.strategy
+	if strategy == nil {
+		strategy = FIFORequestStrategy{}
+	}
+	return candidates[strategy.Select(candidates, q.availability, q.downloaded)]
+}
+
+// requeue puts a piece back on the queue, e.g. after a failed download or
+// integrity check, at the given priority.
+func (q *pieceQueue) requeue(pw *pieceWork, priority PiecePriority) {
+	q.enqueue(pw, priority)
+}
+
+// enterEndgame switches the queue into endgame mode. From this point on,
+// next() broadcasts the pieces still queued to every idle peer that has them
+// instead of handing each piece to a single worker. Pieces that next()
+// already handed out before the switch are not broadcast again; they stay
+// with the worker that received them.
+func (q *pieceQueue) enterEndgame() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.endgame {
+		return
+	}
+	q.endgame = true
+	q.inFlight = make(map[int][]*endgameAttempt)
+	q.cond.Broadcast()
+}
+
+// complete marks pw as finished by winner, removing it from the queue and,
+// in endgame mode, cancelling every other worker still attempting it.
+// alreadyDone reports whether some other worker already completed pw first
+// (possible in endgame mode, where several workers race the same piece);
+// callers should treat that as a no-op rather than a second successful
+// download.
+func (q *pieceQueue) complete(pw *pieceWork, winner *Client) (alreadyDone bool, losers []*endgameAttempt) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.done[pw.index] {
+		return true, nil
+	}
+	q.done[pw.index] = true
+
+	if qp, ok := q.items[pw.index]; ok {
+		heap.Remove(&q.order, qp.heapIdx)
+		delete(q.items, pw.index)
+	}
+	attempts := q.inFlight[pw.index]
+	delete(q.inFlight, pw.index)
+
+	for _, attempt := range attempts {
+		if attempt.client == winner {
+			continue
+		}
+		close(attempt.cancel)
+		losers = append(losers, attempt)
+	}
+	return false, losers
+}
+
+func (q *pieceQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
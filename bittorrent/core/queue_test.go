@@ -0,0 +1,160 @@
+package core
+
+import (
+	"container/heap"
+	"gotorrent/bittorrent/network"
+	"testing"
+)
+
+// clientWithPieces builds a *Client whose bitfield has exactly the given
+// piece indices set, for driving pieceQueue selection in tests.
+func clientWithPieces(numPieces int, pieces ...int) *Client {
+	bf := make(network.Bitfield, (numPieces+7)/8)
+	for _, p := range pieces {
+		bf.SetPiece(p)
+	}
+	return &Client{Bitfield: bf}
+}
+
+func TestPieceHeapOrdersByPriorityThenIndex(t *testing.T) {
+	h := &pieceHeap{}
+	heap.Init(h)
+	heap.Push(h, &queuedPiece{work: &pieceWork{index: 2}, priority: PriorityNormal})
+	heap.Push(h, &queuedPiece{work: &pieceWork{index: 0}, priority: PriorityNow})
+	heap.Push(h, &queuedPiece{work: &pieceWork{index: 1}, priority: PriorityNormal})
+	heap.Push(h, &queuedPiece{work: &pieceWork{index: 3}, priority: PriorityReadahead})
+
+	var order []int
+	for h.Len() > 0 {
+		qp := heap.Pop(h).(*queuedPiece)
+		order = append(order, qp.work.index)
+	}
+
+	want := []int{0, 3, 1, 2} // PriorityNow, then Readahead, then Normal (index 1 before 2)
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+}
+
+func TestPieceQueueRaisePromotesPriority(t *testing.T) {
+	q := newPieceQueue(4)
+	q.enqueue(&pieceWork{index: 0}, PriorityNormal)
+
+	q.raise(0, PriorityNow)
+	if got := q.items[0].priority; got != PriorityNow {
+		t.Errorf("priority after raise = %v, want PriorityNow", got)
+	}
+
+	// A raise to a lower-or-equal priority is a no-op.
+	q.raise(0, PriorityNormal)
+	if got := q.items[0].priority; got != PriorityNow {
+		t.Errorf("priority after no-op raise = %v, want PriorityNow", got)
+	}
+
+	// Raising a piece that isn't queued (e.g. already in flight) is a no-op,
+	// not a panic.
+	q.raise(99, PriorityNow)
+}
+
+func TestPieceQueueSelectLockedPrefersHighestPriorityCoveredByBitfield(t *testing.T) {
+	q := newPieceQueue(4)
+	q.enqueue(&pieceWork{index: 0}, PriorityNormal)
+	q.enqueue(&pieceWork{index: 1}, PriorityNow) // peer below doesn't have this piece
+	q.enqueue(&pieceWork{index: 2}, PriorityNormal)
+
+	// Peer only has pieces 0 and 2, so piece 1's higher priority shouldn't
+	// matter; among 0 and 2 (tied at PriorityNormal), FIFO breaks ties by
+	// lowest index.
+	c := clientWithPieces(4, 0, 2)
+
+	q.mu.Lock()
+	qp := q.selectLocked(c)
+	q.mu.Unlock()
+
+	if qp == nil || qp.work.index != 0 {
+		t.Fatalf("selectLocked returned %v, want piece 0", qp)
+	}
+}
+
+func TestPieceQueueNextRemovesPieceOutsideEndgame(t *testing.T) {
+	q := newPieceQueue(1)
+	pw := &pieceWork{index: 0}
+	q.enqueue(pw, PriorityNormal)
+
+	c := clientWithPieces(1, 0)
+	got, cancel, ok := q.next(c)
+	if !ok || got != pw {
+		t.Fatalf("next() = (%v, _, %v), want (%v, _, true)", got, ok, pw)
+	}
+	if cancel == nil {
+		t.Error("next() returned a nil cancel channel")
+	}
+	if _, ok := q.items[0]; ok {
+		t.Error("piece still present in queue after non-endgame dispatch")
+	}
+}
+
+func TestPieceQueueCompleteIsIdempotent(t *testing.T) {
+	q := newPieceQueue(1)
+	pw := &pieceWork{index: 0}
+	q.enqueue(pw, PriorityNormal)
+
+	winner := clientWithPieces(1, 0)
+	alreadyDone, losers := q.complete(pw, winner)
+	if alreadyDone {
+		t.Fatal("first complete() reported alreadyDone")
+	}
+	if len(losers) != 0 {
+		t.Fatalf("first complete() reported %d losers, want 0", len(losers))
+	}
+
+	alreadyDone, losers = q.complete(pw, winner)
+	if !alreadyDone {
+		t.Error("second complete() of the same piece should report alreadyDone")
+	}
+	if len(losers) != 0 {
+		t.Errorf("second complete() reported %d losers, want 0", len(losers))
+	}
+}
+
+func TestPieceQueueEndgameBroadcastsAndCancelsLosers(t *testing.T) {
+	q := newPieceQueue(1)
+	pw := &pieceWork{index: 0}
+	q.enqueue(pw, PriorityNormal)
+	q.enterEndgame()
+
+	first := clientWithPieces(1, 0)
+	second := clientWithPieces(1, 0)
+
+	// In endgame mode, the piece stays queued so a second worker can also
+	// be handed it instead of it being removed after the first dispatch.
+	if _, _, ok := q.next(first); !ok {
+		t.Fatal("next() for first worker failed")
+	}
+	if _, ok := q.items[0]; !ok {
+		t.Error("piece removed from queue during endgame dispatch, want it to stay queued")
+	}
+	_, secondCancel, ok := q.next(second)
+	if !ok {
+		t.Fatal("next() for second worker failed")
+	}
+
+	alreadyDone, losers := q.complete(pw, first)
+	if alreadyDone {
+		t.Fatal("complete() by the winner reported alreadyDone")
+	}
+	if len(losers) != 1 || losers[0].client != second {
+		t.Fatalf("losers = %v, want exactly the second worker's attempt", losers)
+	}
+
+	select {
+	case <-secondCancel:
+	default:
+		t.Error("second worker's cancel channel was not closed")
+	}
+}
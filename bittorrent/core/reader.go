@@ -0,0 +1,71 @@
+package core
+
+import "io"
+
+// DefaultReadaheadBytes is the readahead window a TorrentReader uses when
+// none is configured explicitly.
+const DefaultReadaheadBytes = 4 * 1024 * 1024 // 4 MiB
+
+// TorrentReader streams a Torrent's data before the download finishes. Each
+// read raises the priority of the pieces it touches so the scheduler fetches
+// them ahead of whatever else is in progress, then blocks until they arrive.
+type TorrentReader struct {
+	t         *Torrent
+	off       int64
+	readahead int
+}
+
+// Reader returns a TorrentReader positioned at the start of the torrent,
+// using DefaultReadaheadBytes. It can be called before, or concurrently
+// with, Download(): both paths share the same lazily-initialized queue,
+// wait condition, and storage backend.
+func (t *Torrent) Reader() *TorrentReader {
+	t.ensureInit()
+	return &TorrentReader{t: t, readahead: DefaultReadaheadBytes}
+}
+
+// SetReadahead overrides the number of bytes beyond the piece being read
+// that get prioritized for background download.
+func (r *TorrentReader) SetReadahead(bytes int) {
+	r.readahead = bytes
+}
+
+// prioritize raises the piece covering off to PriorityNow, the following
+// piece to PriorityNext, and the readahead window beyond that to
+// PriorityReadahead.
+func (r *TorrentReader) prioritize(off int64) {
+	start := int(off) / r.t.PieceLength
+	r.t.queue.raise(start, PriorityNow)
+
+	next := start + 1
+	if next < len(r.t.PieceHashes) {
+		r.t.queue.raise(next, PriorityNext)
+	}
+
+	readaheadPieces := r.readahead / r.t.PieceLength
+	for i := next + 1; i < len(r.t.PieceHashes) && i <= next+readaheadPieces; i++ {
+		r.t.queue.raise(i, PriorityReadahead)
+	}
+}
+
+// ReadAt implements io.ReaderAt. It blocks until the requested range has
+// downloaded.
+func (r *TorrentReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(r.t.Length) {
+		return 0, io.EOF
+	}
+	r.prioritize(off)
+	return r.t.waitForRange(off, p)
+}
+
+// Read implements io.Reader using the reader's own offset cursor.
+func (r *TorrentReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+var (
+	_ io.ReaderAt = (*TorrentReader)(nil)
+	_ io.Reader   = (*TorrentReader)(nil)
+)
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"gotorrent/bittorrent/network"
+	"gotorrent/bittorrent/storage"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// unchokeInterval is how often the choking algorithm re-ranks peers and
+	// picks who to unchoke.
+	unchokeInterval = 10 * time.Second
+	// optimisticUnchokeInterval is how often an extra, randomly-chosen peer
+	// is unchoked regardless of rank, so new or slow peers still get a
+	// chance to prove themselves.
+	optimisticUnchokeInterval = 30 * time.Second
+)
+
+// Seed listens for incoming peer connections on port and serves their piece
+// requests out of t.storage, running the standard choking algorithm to
+// decide who to unchoke. It's meant to be called after Download() completes,
+// or directly for a torrent resumed from complete storage via
+// storage.ScanComplete. It blocks until ctx is cancelled.
+func (t *Torrent) Seed(ctx context.Context, port int) error {
+	if t.storage == nil {
+		return fmt.Errorf("cannot seed %s: no storage configured", t.Name)
+	}
+
+	if t.havePieces == nil {
+		have, err := storage.ScanComplete(t.storage, t.PieceHashes, t.PieceLength, t.Length)
+		if err != nil {
+			return fmt.Errorf("seed %s: %w", t.Name, err)
+		}
+		t.havePieces = have
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("seed %s: %w", t.Name, err)
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	chokes := newChokeManager()
+	go t.runChokeRotation(ctx, chokes)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Seed accept error: %v", err)
+			continue
+		}
+		go t.serveIncoming(conn, chokes)
+	}
+}
+
+// runChokeRotation drives the unchoke and optimistic-unchoke tickers until
+// ctx is cancelled.
+func (t *Torrent) runChokeRotation(ctx context.Context, chokes *chokeManager) {
+	unchokeTicker := time.NewTicker(unchokeInterval)
+	defer unchokeTicker.Stop()
+	optimisticTicker := time.NewTicker(optimisticUnchokeInterval)
+	defer optimisticTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-unchokeTicker.C:
+			chokes.rotate(false)
+		case <-optimisticTicker.C:
+			chokes.rotate(true)
+		}
+	}
+}
+
+// serveIncoming completes the handshake for one incoming connection, then
+// serves MsgRequest messages from storage until the peer disconnects.
+func (t *Torrent) serveIncoming(conn net.Conn, chokes *chokeManager) {
+	c, err := AcceptClient(conn, t.PeerID, t.InfoHash)
+	if err != nil {
+		log.Printf("Failed incoming handshake from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer c.Conn.Close()
+
+	pc := &PeerConn{client: c, stats: t.statsFor(conn.RemoteAddr().String()), choked: true}
+	chokes.add(pc)
+	defer chokes.remove(pc)
+
+	pex := &pexConn{client: c, discovered: t.pexNew}
+	pc.mu.Lock()
+	if err := sendExtendedHandshake(c); err != nil {
+		log.Printf("Failed to send extension handshake: %v", err)
+	}
+	if err := c.SendChoke(); err != nil {
+		log.Printf("Failed to send initial choke: %v", err)
+	}
+	if err := c.SendBitfield(t.havePieces); err != nil {
+		log.Printf("Failed to send bitfield: %v", err)
+	}
+	pc.mu.Unlock()
+
+	for {
+		msg, err := c.Read()
+		if err != nil {
+			return
+		}
+		if msg == nil { // keep-alive
+			continue
+		}
+
+		switch msg.ID {
+		case network.MsgExtended:
+			id, payload, err := network.ParseExtended(msg)
+			if err != nil {
+				log.Printf("Bad extended message from %s: %v", conn.RemoteAddr(), err)
+				continue
+			}
+			if id == 0 {
+				pc.mu.Lock() // the handshake reply writes to c.Conn
+				handleExtendedHandshake(pex, payload, t.Peers)
+				pc.mu.Unlock()
+			} else if id == ourPEXExtendedID {
+				handlePEXMessage(pex, payload)
+			}
+		case network.MsgRequest:
+			if pc.isChoked() {
+				continue
+			}
+			index, begin, length, err := network.ParseRequest(msg)
+			if err != nil {
+				log.Printf("Bad request from %s: %v", conn.RemoteAddr(), err)
+				continue
+			}
+			if err := t.servePieceRequest(pc, index, begin, length); err != nil {
+				log.Printf("Failed to serve piece #%d to %s: %v", index, conn.RemoteAddr(), err)
+				return
+			}
+		case network.MsgCancel:
+			// We serve requests synchronously and don't queue outbound
+			// blocks, so there's nothing in flight left to cancel.
+		}
+	}
+}
+
+// servePieceRequest reads the requested block out of storage and sends it.
+func (t *Torrent) servePieceRequest(pc *PeerConn, index, begin, length int) error {
+	pieceBegin, pieceEnd := t.calculateBoundsForPiece(index)
+	piece, err := t.storage.Piece(storage.PieceInfo{Index: index, Length: pieceEnd - pieceBegin, Offset: int64(pieceBegin)})
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, length)
+	if _, err := piece.ReadAt(buf, int64(begin)); err != nil {
+		return err
+	}
+	if err := pc.sendPiece(index, begin, buf); err != nil {
+		return err
+	}
+
+	pc.stats.RecordUpload(length)
+	return nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// statsEWMAWindow is the time constant for PeerStats' rolling rate
+// estimate, matching the ~20s window real BitTorrent clients use to judge
+// who to unchoke.
+const statsEWMAWindow = 20 * time.Second
+
+// PeerStats tracks bytes moved across a single peer connection, plus
+// exponentially-weighted moving average rates the choking algorithm ranks
+// peers by.
+type PeerStats struct {
+	mu        sync.Mutex
+	bytesUp   int64
+	bytesDown int64
+	upRate    float64 // bytes/sec EWMA
+	downRate  float64 // bytes/sec EWMA
+	lastUp    time.Time
+	lastDown  time.Time
+}
+
+func newPeerStats() *PeerStats {
+	now := time.Now()
+	return &PeerStats{lastUp: now, lastDown: now}
+}
+
+// RecordDownload adds n bytes received from this peer and updates the
+// rolling download rate.
+func (s *PeerStats) RecordDownload(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesDown += int64(n)
+	s.downRate, s.lastDown = ewmaSample(s.downRate, s.lastDown, n)
+}
+
+// RecordUpload adds n bytes sent to this peer and updates the rolling
+// upload rate.
+func (s *PeerStats) RecordUpload(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytesUp += int64(n)
+	s.upRate, s.lastUp = ewmaSample(s.upRate, s.lastUp, n)
+}
+
+// ewmaSample folds n bytes observed now into rate, decaying prior samples
+// by how long it's been since last, over statsEWMAWindow.
+func ewmaSample(rate float64, last time.Time, n int) (float64, time.Time) {
+	now := time.Now()
+	elapsed := now.Sub(last)
+	if elapsed <= 0 {
+		return rate, now
+	}
+	decay := math.Exp(-float64(elapsed) / float64(statsEWMAWindow))
+	instant := float64(n) / elapsed.Seconds()
+	return rate*decay + instant*(1-decay), now
+}
+
+// Snapshot returns a point-in-time copy of the stats, safe to read
+// concurrently with further Record calls.
+func (s *PeerStats) Snapshot() PeerStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PeerStatsSnapshot{
+		BytesUp:   s.bytesUp,
+		BytesDown: s.bytesDown,
+		UpRate:    s.upRate,
+		DownRate:  s.downRate,
+	}
+}
+
+// PeerStatsSnapshot is an immutable view of a PeerStats at one instant.
+type PeerStatsSnapshot struct {
+	BytesUp   int64
+	BytesDown int64
+	UpRate    float64 // bytes/sec
+	DownRate  float64 // bytes/sec
+}
+
+// TorrentStats is the aggregate and per-peer transfer totals returned by
+// Torrent.Stats, e.g. for the UI's progress bar.
+type TorrentStats struct {
+	BytesUp   int64
+	BytesDown int64
+	Peers     map[string]PeerStatsSnapshot // keyed by peer address
+}
+
+// Stats returns the current aggregate and per-peer upload/download totals.
+func (t *Torrent) Stats() TorrentStats {
+	t.peersMu.Lock()
+	defer t.peersMu.Unlock()
+
+	stats := TorrentStats{Peers: make(map[string]PeerStatsSnapshot, len(t.peerStats))}
+	for addr, s := range t.peerStats {
+		snap := s.Snapshot()
+		stats.Peers[addr] = snap
+		stats.BytesUp += snap.BytesUp
+		stats.BytesDown += snap.BytesDown
+	}
+	return stats
+}
+
+// statsFor returns the shared PeerStats for addr, creating it on first use.
+func (t *Torrent) statsFor(addr string) *PeerStats {
+	t.peersMu.Lock()
+	defer t.peersMu.Unlock()
+	if t.peerStats == nil {
+		t.peerStats = make(map[string]*PeerStats)
+	}
+	s, ok := t.peerStats[addr]
+	if !ok {
+		s = newPeerStats()
+		t.peerStats[addr] = s
+	}
+	return s
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEwmaSampleFirstSampleIsInstantRate(t *testing.T) {
+	// With elapsed several EWMA windows long, decay is close to zero, so a
+	// zero-valued prior rate is swamped by the instantaneous rate.
+	elapsed := 5 * statsEWMAWindow
+	last := time.Now().Add(-elapsed)
+	n := int(1000 * elapsed.Seconds()) // -> instant rate of 1000 bytes/sec
+	rate, newLast := ewmaSample(0, last, n)
+
+	if rate <= 900 || rate >= 1100 {
+		t.Errorf("rate = %v, want close to 1000 bytes/sec", rate)
+	}
+	if !newLast.After(last) {
+		t.Errorf("newLast = %v, want after %v", newLast, last)
+	}
+}
+
+func TestEwmaSampleDecaysTowardNewRate(t *testing.T) {
+	// A prior high rate should decay toward a new, much lower instantaneous
+	// rate once enough time (several EWMA windows) has passed.
+	last := time.Now().Add(-5 * statsEWMAWindow)
+	rate, _ := ewmaSample(10000, last, 1)
+
+	if rate >= 100 {
+		t.Errorf("rate = %v, want decayed close to the new near-zero instant rate after 5 windows", rate)
+	}
+}
+
+func TestEwmaSampleNonPositiveElapsedIsNoOp(t *testing.T) {
+	// A sample whose "last" timestamp is still in the future (elapsed <= 0)
+	// shouldn't divide by zero or mutate the rate.
+	before := time.Now()
+	future := before.Add(time.Hour)
+	rate, newLast := ewmaSample(42, future, 1000)
+
+	if rate != 42 {
+		t.Errorf("rate = %v, want unchanged 42", rate)
+	}
+	if newLast.Before(before) {
+		t.Errorf("newLast = %v, want not before %v", newLast, before)
+	}
+}
+
+func TestPeerStatsRecordAccumulatesBytes(t *testing.T) {
+	s := newPeerStats()
+	s.RecordDownload(100)
+	s.RecordDownload(200)
+	s.RecordUpload(50)
+
+	snap := s.Snapshot()
+	if snap.BytesDown != 300 {
+		t.Errorf("BytesDown = %d, want 300", snap.BytesDown)
+	}
+	if snap.BytesUp != 50 {
+		t.Errorf("BytesUp = %d, want 50", snap.BytesUp)
+	}
+	if snap.DownRate <= 0 {
+		t.Errorf("DownRate = %v, want > 0 after recording downloads", snap.DownRate)
+	}
+}
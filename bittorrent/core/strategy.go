@@ -0,0 +1,63 @@
+package core
+
+import "math/rand"
+
+// RequestStrategy picks which of several equally-high-priority candidate
+// pieces a worker should request next, once the priority queue has already
+// narrowed the field down to pieces the worker's peer can actually serve.
+type RequestStrategy interface {
+	// Select returns the index into candidates of the piece to request.
+	// downloaded is how many pieces of the torrent have completed so far.
+	Select(candidates []*queuedPiece, availability *availabilityTracker, downloaded int) int
+}
+
+// FIFORequestStrategy requests pieces in queued order (lowest index first),
+// ignoring swarm availability. This is the original, simplest behavior.
+type FIFORequestStrategy struct{}
+
+func (FIFORequestStrategy) Select(candidates []*queuedPiece, _ *availabilityTracker, _ int) int {
+	best := 0
+	for i, qp := range candidates {
+		if qp.work.index < candidates[best].work.index {
+			best = i
+		}
+	}
+	return best
+}
+
+// RandomRequestStrategy requests a uniformly random candidate piece.
+type RandomRequestStrategy struct{}
+
+func (RandomRequestStrategy) Select(candidates []*queuedPiece, _ *availabilityTracker, _ int) int {
+	return rand.Intn(len(candidates))
+}
+
+// RarestFirstRequestStrategy requests whichever candidate has the fewest
+// copies in the swarm, ties broken randomly so workers don't all pile onto
+// the same rarest piece at once. Until BootstrapPieces pieces have completed,
+// candidates are picked at random instead: a freshly connected swarm hasn't reported
+// enough Bitfields/Haves yet for availability counts to be meaningful, and
+// random variety gets every peer started with different pieces to trade.
+type RarestFirstRequestStrategy struct {
+	BootstrapPieces int
+}
+
+func (s RarestFirstRequestStrategy) Select(candidates []*queuedPiece, availability *availabilityTracker, downloaded int) int {
+	if downloaded < s.BootstrapPieces {
+		return rand.Intn(len(candidates))
+	}
+
+	best := []int{0}
+	bestCount := availability.count(candidates[0].work.index)
+	for i := 1; i < len(candidates); i++ {
+		count := availability.count(candidates[i].work.index)
+		switch {
+		case count < bestCount:
+			bestCount = count
+			best = []int{i}
+		case count == bestCount:
+			best = append(best, i)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
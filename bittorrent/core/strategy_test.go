@@ -0,0 +1,75 @@
+package core
+
+import "testing"
+
+func candidatesForIndices(indices ...int) []*queuedPiece {
+	candidates := make([]*queuedPiece, len(indices))
+	for i, idx := range indices {
+		candidates[i] = &queuedPiece{work: &pieceWork{index: idx}}
+	}
+	return candidates
+}
+
+func TestFIFORequestStrategyPicksLowestIndex(t *testing.T) {
+	candidates := candidatesForIndices(5, 1, 3)
+	got := FIFORequestStrategy{}.Select(candidates, nil, 0)
+	if candidates[got].work.index != 1 {
+		t.Errorf("selected index %d, want piece 1", candidates[got].work.index)
+	}
+}
+
+func TestRarestFirstRequestStrategyBootstrapsRandomly(t *testing.T) {
+	candidates := candidatesForIndices(0, 1, 2)
+	availability := newAvailabilityTracker(3)
+	availability.have(0)
+	availability.have(0) // piece 0 has 2 copies, would be the least rare, not rarest
+
+	strategy := RarestFirstRequestStrategy{BootstrapPieces: 4}
+	pickedLeastRare := false
+	for i := 0; i < 50; i++ {
+		got := strategy.Select(candidates, availability, 0) // downloaded < BootstrapPieces
+		if got < 0 || got >= len(candidates) {
+			t.Fatalf("Select returned out-of-range index %d", got)
+		}
+		if candidates[got].work.index == 0 {
+			pickedLeastRare = true
+		}
+	}
+	if !pickedLeastRare {
+		t.Error("Select never picked piece 0 across 50 bootstrap calls, want random choice regardless of availability")
+	}
+}
+
+func TestRarestFirstRequestStrategyPrefersLeastAvailable(t *testing.T) {
+	candidates := candidatesForIndices(0, 1, 2)
+	availability := newAvailabilityTracker(3)
+	availability.have(0)
+	availability.have(0)
+	availability.have(1)
+	// piece 2 has 0 copies recorded: it's the rarest.
+
+	strategy := RarestFirstRequestStrategy{BootstrapPieces: 0}
+	got := strategy.Select(candidates, availability, 5) // past the bootstrap window
+	if candidates[got].work.index != 2 {
+		t.Errorf("selected index %d, want the rarest piece (2)", candidates[got].work.index)
+	}
+}
+
+func TestRarestFirstRequestStrategyBreaksTiesRandomly(t *testing.T) {
+	candidates := candidatesForIndices(0, 1, 2)
+	availability := newAvailabilityTracker(3)
+	// All three pieces tied at 0 copies.
+
+	strategy := RarestFirstRequestStrategy{BootstrapPieces: 0}
+	seen := make(map[int]bool)
+	for i := 0; i < 50; i++ {
+		got := strategy.Select(candidates, availability, 5)
+		if got < 0 || got >= len(candidates) {
+			t.Fatalf("Select returned out-of-range index %d", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Select only ever returned %v across 50 tied calls, want variety", seen)
+	}
+}
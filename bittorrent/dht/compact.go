@@ -0,0 +1,42 @@
+package dht
+
+import (
+	"encoding/binary"
+	"gotorrent/bittorrent/network"
+	"net"
+)
+
+// decodeCompactPeers parses BEP 5's compact peer info: 6 bytes per peer,
+// a 4-byte IPv4 address followed by a 2-byte big-endian port.
+func decodeCompactPeers(s string) []network.Peer {
+	const entrySize = 6
+	b := []byte(s)
+	peers := make([]network.Peer, 0, len(b)/entrySize)
+	for i := 0; i+entrySize <= len(b); i += entrySize {
+		ip := net.IPv4(b[i], b[i+1], b[i+2], b[i+3])
+		port := binary.BigEndian.Uint16(b[i+4 : i+6])
+		peers = append(peers, network.Peer{IP: ip, Port: int(port)})
+	}
+	return peers
+}
+
+// compactNode is one entry of BEP 5's compact node info: 20-byte node ID,
+// 4-byte IPv4 address, 2-byte big-endian port.
+type compactNode struct {
+	id   NodeID
+	addr *net.UDPAddr
+}
+
+func decodeCompactNodes(s string) []compactNode {
+	const entrySize = 26
+	b := []byte(s)
+	nodes := make([]compactNode, 0, len(b)/entrySize)
+	for i := 0; i+entrySize <= len(b); i += entrySize {
+		var id NodeID
+		copy(id[:], b[i:i+20])
+		ip := net.IPv4(b[i+20], b[i+21], b[i+22], b[i+23])
+		port := binary.BigEndian.Uint16(b[i+24 : i+26])
+		nodes = append(nodes, compactNode{id: id, addr: &net.UDPAddr{IP: ip, Port: int(port)}})
+	}
+	return nodes
+}
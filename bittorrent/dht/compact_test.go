@@ -0,0 +1,53 @@
+package dht
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDecodeCompactPeers(t *testing.T) {
+	// Two peers: 192.168.0.1:6881 and 10.0.0.2:51413.
+	raw := []byte{192, 168, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0xc8, 0xd5}
+
+	peers := decodeCompactPeers(string(raw))
+	if len(peers) != 2 {
+		t.Fatalf("got %d peers, want 2", len(peers))
+	}
+
+	if !peers[0].IP.Equal(net.IPv4(192, 168, 0, 1)) || peers[0].Port != 6881 {
+		t.Errorf("peer 0 = %s:%d, want 192.168.0.1:6881", peers[0].IP, peers[0].Port)
+	}
+	if !peers[1].IP.Equal(net.IPv4(10, 0, 0, 2)) || peers[1].Port != 51413 {
+		t.Errorf("peer 1 = %s:%d, want 10.0.0.2:51413", peers[1].IP, peers[1].Port)
+	}
+}
+
+func TestDecodeCompactPeersTruncated(t *testing.T) {
+	// One full entry plus 3 trailing bytes that don't form a second one.
+	raw := []byte{192, 168, 0, 1, 0x1a, 0xe1, 1, 2, 3}
+
+	peers := decodeCompactPeers(string(raw))
+	if len(peers) != 1 {
+		t.Fatalf("got %d peers, want 1 (trailing partial entry dropped)", len(peers))
+	}
+}
+
+func TestDecodeCompactNodes(t *testing.T) {
+	var id NodeID
+	for i := range id {
+		id[i] = byte(i)
+	}
+
+	raw := append(append([]byte{}, id[:]...), 127, 0, 0, 1, 0x1a, 0xe1)
+
+	nodes := decodeCompactNodes(string(raw))
+	if len(nodes) != 1 {
+		t.Fatalf("got %d nodes, want 1", len(nodes))
+	}
+	if nodes[0].id != id {
+		t.Errorf("node id = %x, want %x", nodes[0].id, id)
+	}
+	if !nodes[0].addr.IP.Equal(net.IPv4(127, 0, 0, 1)) || nodes[0].addr.Port != 6881 {
+		t.Errorf("node addr = %s, want 127.0.0.1:6881", nodes[0].addr)
+	}
+}
@@ -0,0 +1,199 @@
+// Package dht implements a minimal Kademlia DHT client (BEP 5): enough to
+// bootstrap from well-known nodes, run a get_peers lookup for a single
+// torrent's infohash, and periodically re-announce. It keeps a flat list
+// of known nodes rather than a full XOR-distance routing table with
+// k-buckets — good enough for finding peers for one torrent, not for
+// running a general-purpose DHT node.
+package dht
+
+import (
+	"context"
+	"crypto/rand"
+	"gotorrent/bittorrent/network"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// BootstrapNodes are well-known, long-lived DHT nodes used to join the
+// network when we don't have a routing table yet.
+var BootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+// ReannounceInterval is how often Client re-announces the torrent to keep
+// it discoverable and refresh the peers it knows about.
+const ReannounceInterval = 15 * time.Minute
+
+const queryTimeout = 5 * time.Second
+
+// NodeID is a DHT node's 160-bit Kademlia identifier.
+type NodeID [20]byte
+
+// Client is a single-torrent DHT node.
+type Client struct {
+	id       NodeID
+	conn     *net.UDPConn
+	infoHash [20]byte
+	port     int // the port we advertise for incoming peer connections
+
+	known     []compactNode
+	txCounter int // transaction id counter; only ever touched by this Client's own Run goroutine
+}
+
+// New creates a Client with a random node ID, bound to an ephemeral UDP
+// port, for looking up peers of infoHash. port is our own listening port,
+// advertised via announce_peer.
+func New(infoHash [20]byte, port int) (*Client, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+
+	var id NodeID
+	if _, err := rand.Read(id[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{id: id, conn: conn, infoHash: infoHash, port: port}
+	for _, addr := range BootstrapNodes {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			log.Printf("dht: could not resolve bootstrap node %s: %v", addr, err)
+			continue
+		}
+		c.known = append(c.known, compactNode{addr: udpAddr})
+	}
+
+	return c, nil
+}
+
+// Close releases the client's UDP socket.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Run performs an initial get_peers lookup and then re-announces every
+// ReannounceInterval, sending every newly discovered peer to peers. It
+// blocks until ctx is cancelled.
+func (c *Client) Run(ctx context.Context, peers chan<- network.Peer) {
+	c.lookup(ctx, peers)
+
+	ticker := time.NewTicker(ReannounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.lookup(ctx, peers)
+		}
+	}
+}
+
+// lookup walks the nodes we currently know about, running get_peers
+// against each: nodes that have peers contribute them to out, nodes that
+// don't return closer nodes which get added to c.known for the next round.
+// It's a single best-effort pass, not a full iterative Kademlia lookup
+// converging on the infohash's XOR-closest nodes.
+func (c *Client) lookup(ctx context.Context, out chan<- network.Peer) {
+	frontier := c.known
+	seen := make(map[string]bool, len(frontier))
+
+	for i := 0; i < 8 && i < len(frontier); i++ { // cap rounds; this is best-effort discovery, not exhaustive
+		node := frontier[i]
+		addrKey := node.addr.String()
+		if seen[addrKey] {
+			continue
+		}
+		seen[addrKey] = true
+
+		resp, err := c.getPeers(node.addr)
+		if err != nil {
+			continue
+		}
+		for _, v := range resp.values {
+			for _, peer := range decodeCompactPeers(v) {
+				select {
+				case out <- peer:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		for _, n := range resp.nodes {
+			c.known = append(c.known, n)
+			frontier = append(frontier, n)
+		}
+
+		c.announce(node.addr, resp.token)
+	}
+}
+
+func (c *Client) getPeers(addr *net.UDPAddr) (krpcResponse, error) {
+	txID := c.nextTxID()
+	query := newQuery(txID, "get_peers", map[string]interface{}{
+		"id":        string(c.id[:]),
+		"info_hash": string(c.infoHash[:]),
+	})
+	return c.roundTrip(addr, txID, query)
+}
+
+// announce tells addr that we're a peer for c.infoHash, echoing token from
+// that node's get_peers reply as BEP 5 requires to prove we did the lookup.
+// Nodes that didn't return one (e.g. the query failed) can't be announced
+// to and are skipped.
+func (c *Client) announce(addr *net.UDPAddr, token string) {
+	if token == "" {
+		return
+	}
+
+	txID := c.nextTxID()
+	query := newQuery(txID, "announce_peer", map[string]interface{}{
+		"id":        string(c.id[:]),
+		"info_hash": string(c.infoHash[:]),
+		"port":      int64(c.port),
+		"token":     token,
+	})
+	if _, err := c.roundTrip(addr, txID, query); err != nil {
+		log.Printf("dht: announce to %s failed: %v", addr, err)
+	}
+}
+
+// roundTrip sends query to addr and returns its reply, discarding any
+// packet that isn't from addr or doesn't echo txID and waiting for the
+// next one instead -- the shared UDP socket can have a stray or late
+// datagram from an earlier round arrive while this query is in flight, and
+// it shouldn't be mistaken for this one's reply.
+func (c *Client) roundTrip(addr *net.UDPAddr, txID string, query []byte) (krpcResponse, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(queryTimeout)); err != nil {
+		return krpcResponse{}, err
+	}
+	if _, err := c.conn.WriteToUDP(query, addr); err != nil {
+		return krpcResponse{}, err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			return krpcResponse{}, err
+		}
+		if !from.IP.Equal(addr.IP) || from.Port != addr.Port {
+			continue
+		}
+		resp, err := parseResponse(buf[:n])
+		if err != nil || resp.txID != txID {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func (c *Client) nextTxID() string {
+	c.txCounter++
+	return strconv.Itoa(c.txCounter)
+}
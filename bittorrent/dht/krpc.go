@@ -0,0 +1,69 @@
+package dht
+
+import (
+	"fmt"
+	"gotorrent/bittorrent/bencode"
+)
+
+// newQuery builds a KRPC query message: {"t": txID, "y": "q", "q": method,
+// "a": args}.
+func newQuery(txID string, method string, args map[string]interface{}) []byte {
+	return bencode.Encode(map[string]interface{}{
+		"t": txID,
+		"y": "q",
+		"q": method,
+		"a": args,
+	})
+}
+
+// krpcResponse is the "r" dict of a KRPC reply, decoded just enough to
+// drive our lookups.
+type krpcResponse struct {
+	txID   string        // echoed "t", checked against the query we sent
+	id     NodeID
+	token  string        // get_peers' opaque token, echoed back in announce_peer
+	nodes  []compactNode // present on find_node replies, and get_peers misses
+	values []string      // present on get_peers hits: compact peer info strings
+}
+
+// parseResponse decodes a KRPC reply packet, returning an error for
+// anything that isn't a well-formed "r" response (queries, errors,
+// malformed packets are all rejected the same way since we only initiate
+// queries, never serve them).
+func parseResponse(b []byte) (krpcResponse, error) {
+	v, err := bencode.Decode(b)
+	if err != nil {
+		return krpcResponse{}, err
+	}
+	msg, ok := v.(map[string]interface{})
+	if !ok {
+		return krpcResponse{}, fmt.Errorf("dht: reply is not a dict")
+	}
+	if y, _ := msg["y"].(string); y != "r" {
+		return krpcResponse{}, fmt.Errorf("dht: not a response (y=%v)", msg["y"])
+	}
+	r, ok := msg["r"].(map[string]interface{})
+	if !ok {
+		return krpcResponse{}, fmt.Errorf("dht: response missing \"r\" dict")
+	}
+
+	var resp krpcResponse
+	resp.txID, _ = msg["t"].(string)
+	if idStr, ok := r["id"].(string); ok {
+		copy(resp.id[:], idStr)
+	}
+	if token, ok := r["token"].(string); ok {
+		resp.token = token
+	}
+	if nodesStr, ok := r["nodes"].(string); ok {
+		resp.nodes = decodeCompactNodes(nodesStr)
+	}
+	if values, ok := r["values"].([]interface{}); ok {
+		for _, v := range values {
+			if s, ok := v.(string); ok {
+				resp.values = append(resp.values, s)
+			}
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"io"
+	"sync"
+)
+
+// NewMemory returns a Client that keeps every piece in RAM. It's used by
+// tests and by callers that explicitly want the old in-memory behavior.
+func NewMemory() Client {
+	return &memoryClient{
+		pieces:   make(map[int][]byte),
+		complete: make(map[int]bool),
+	}
+}
+
+type memoryClient struct {
+	mu       sync.Mutex
+	pieces   map[int][]byte
+	complete map[int]bool
+}
+
+func (c *memoryClient) Piece(pi PieceInfo) (PieceImpl, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pieces[pi.Index]; !ok {
+		c.pieces[pi.Index] = make([]byte, pi.Length)
+	}
+	return &memoryPiece{client: c, index: pi.Index}, nil
+}
+
+func (c *memoryClient) Close() error { return nil }
+
+type memoryPiece struct {
+	client *memoryClient
+	index  int
+}
+
+func (p *memoryPiece) ReadAt(b []byte, off int64) (int, error) {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	buf := p.client.pieces[p.index]
+	if off >= int64(len(buf)) {
+		return 0, io.EOF
+	}
+	return copy(b, buf[off:]), nil
+}
+
+func (p *memoryPiece) WriteAt(b []byte, off int64) (int, error) {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	return copy(p.client.pieces[p.index][off:], b), nil
+}
+
+func (p *memoryPiece) MarkComplete() error {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	p.client.complete[p.index] = true
+	return nil
+}
+
+func (p *memoryPiece) Complete() bool {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	return p.client.complete[p.index]
+}
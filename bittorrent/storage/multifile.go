@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileInfo describes one file of a multi-file torrent, as found in the
+// info dict's "files" list.
+type FileInfo struct {
+	Path   []string // path components, relative to the torrent's download dir
+	Length int64
+}
+
+// NewMultiFile returns a Client that lays pieces out across the files
+// described by files, rooted at dir, honoring the info dict's layout:
+// files are concatenated in order to form the single byte stream that
+// pieces are sliced from.
+func NewMultiFile(dir string, files []FileInfo) (Client, error) {
+	c := &multiFileClient{complete: make(map[int]bool)}
+	var offset int64
+	for _, fi := range files {
+		path := filepath.Join(append([]string{dir}, fi.Path...)...)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			c.Close()
+			return nil, err
+		}
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		if err := f.Truncate(fi.Length); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.files = append(c.files, multiFileEntry{f: f, offset: offset, length: fi.Length})
+		offset += fi.Length
+	}
+	return c, nil
+}
+
+type multiFileEntry struct {
+	f      *os.File
+	offset int64
+	length int64
+}
+
+type multiFileClient struct {
+	mu       sync.Mutex
+	files    []multiFileEntry
+	complete map[int]bool
+}
+
+func (c *multiFileClient) Piece(pi PieceInfo) (PieceImpl, error) {
+	return &multiFilePiece{client: c, info: pi}, nil
+}
+
+func (c *multiFileClient) Close() error {
+	var firstErr error
+	for _, fe := range c.files {
+		if err := fe.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// forEachRange splits [globalOff, globalOff+len(b)) across the files it
+// spans and invokes fn with the slice of b and file-relative offset for
+// each segment.
+func (c *multiFileClient) forEachRange(globalOff int64, b []byte, fn func(fe multiFileEntry, seg []byte, fileOff int64) (int, error)) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		fe, idx := c.fileAt(globalOff)
+		if idx < 0 {
+			break
+		}
+		fileOff := globalOff - fe.offset
+		segLen := fe.length - fileOff
+		if int64(len(b)) < segLen {
+			segLen = int64(len(b))
+		}
+		written, err := fn(fe, b[:segLen], fileOff)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		b = b[segLen:]
+		globalOff += segLen
+	}
+	return n, nil
+}
+
+func (c *multiFileClient) fileAt(globalOff int64) (multiFileEntry, int) {
+	for i, fe := range c.files {
+		if globalOff >= fe.offset && globalOff < fe.offset+fe.length {
+			return fe, i
+		}
+	}
+	return multiFileEntry{}, -1
+}
+
+type multiFilePiece struct {
+	client *multiFileClient
+	info   PieceInfo
+}
+
+func (p *multiFilePiece) ReadAt(b []byte, off int64) (int, error) {
+	return p.client.forEachRange(p.info.Offset+off, b, func(fe multiFileEntry, seg []byte, fileOff int64) (int, error) {
+		return fe.f.ReadAt(seg, fileOff)
+	})
+}
+
+func (p *multiFilePiece) WriteAt(b []byte, off int64) (int, error) {
+	return p.client.forEachRange(p.info.Offset+off, b, func(fe multiFileEntry, seg []byte, fileOff int64) (int, error) {
+		return fe.f.WriteAt(seg, fileOff)
+	})
+}
+
+func (p *multiFilePiece) MarkComplete() error {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	p.client.complete[p.info.Index] = true
+	return nil
+}
+
+func (p *multiFilePiece) Complete() bool {
+	p.client.mu.Lock()
+	defer p.client.mu.Unlock()
+	return p.client.complete[p.info.Index]
+}
@@ -0,0 +1,40 @@
+package storage
+
+import "crypto/sha1"
+
+// ScanComplete hashes every piece already present in c and returns which
+// indices match hashes, so a resumed download can skip re-requesting them.
+// Matching pieces are also marked complete in c.
+func ScanComplete(c Client, hashes [][20]byte, pieceLength, totalLength int) ([]bool, error) {
+	have := make([]bool, len(hashes))
+	buf := make([]byte, pieceLength)
+
+	for index, hash := range hashes {
+		offset := index * pieceLength
+		length := pieceLength
+		if offset+length > totalLength {
+			length = totalLength - offset
+		}
+
+		piece, err := c.Piece(PieceInfo{Index: index, Length: length, Offset: int64(offset)})
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := piece.ReadAt(buf[:length], 0)
+		if err != nil && n < length {
+			continue // not fully present yet, leave it queued
+		}
+
+		if sha1.Sum(buf[:length]) != hash {
+			continue
+		}
+
+		if err := piece.MarkComplete(); err != nil {
+			return nil, err
+		}
+		have[index] = true
+	}
+
+	return have, nil
+}
@@ -0,0 +1,37 @@
+// Package storage provides pluggable backends for where a Torrent's piece
+// data actually lives, so Download doesn't have to hold the whole file in
+// RAM.
+package storage
+
+import "io"
+
+// PieceInfo describes a single piece's place within the torrent, enough for
+// a Client to locate it without needing to know about info dicts or
+// multi-file layouts itself.
+type PieceInfo struct {
+	Index  int
+	Length int
+	Offset int64 // byte offset of this piece within the concatenated torrent data
+}
+
+// PieceImpl is the per-piece handle a Client hands out for reading, writing,
+// and tracking completion of a single piece's bytes.
+type PieceImpl interface {
+	io.ReaderAt
+	io.WriterAt
+	// MarkComplete records that the piece has been fully written and
+	// verified, so a future resume scan can skip re-downloading it.
+	MarkComplete() error
+	// Complete reports whether the piece was already marked complete, e.g.
+	// by a resume scan at startup.
+	Complete() bool
+}
+
+// Client opens and manages the on-disk or in-memory storage backing a
+// single torrent's pieces.
+type Client interface {
+	// Piece returns the storage handle for the given piece.
+	Piece(pi PieceInfo) (PieceImpl, error)
+	// Close releases any resources (open file handles) the client holds.
+	Close() error
+}